@@ -1,6 +1,7 @@
 package business
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -16,53 +17,51 @@ import (
 
 type OpenIdMetadata struct {
 	// Taken from https://github.com/coreos/go-oidc/blob/8d771559cf6e5111c9b9159810d0e4538e7cdc82/oidc.go
-	Issuer      string   `json:"issuer"`
-	AuthURL     string   `json:"authorization_endpoint"`
-	TokenURL    string   `json:"token_endpoint"`
-	JWKSURL     string   `json:"jwks_uri"`
-	UserInfoURL string   `json:"userinfo_endpoint"`
-	Algorithms  []string `json:"id_token_signing_alg_values_supported"`
+	Issuer               string   `json:"issuer"`
+	AuthURL              string   `json:"authorization_endpoint"`
+	TokenURL             string   `json:"token_endpoint"`
+	JWKSURL              string   `json:"jwks_uri"`
+	UserInfoURL          string   `json:"userinfo_endpoint"`
+	RegistrationEndpoint string   `json:"registration_endpoint"`
+	Algorithms           []string `json:"id_token_signing_alg_values_supported"`
 
 	// Some extra fields
-	ScopesSupported        []string `json:"scopes_supported"`
-	ResponseTypesSupported []string `json:"response_types_supported"`
+	ScopesSupported               []string `json:"scopes_supported"`
+	ResponseTypesSupported        []string `json:"response_types_supported"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
 }
 
-var cachedOpenIdMetadata *OpenIdMetadata
-
 // GetConfiguredOpenIdScopes gets the list of scopes set in Kiali configuration making sure
 // that the mandatory "openid" scope is present in the returned list.
 func GetConfiguredOpenIdScopes() []string {
-	cfg := config.Get().Auth.OpenId
-	scopes := cfg.Scopes
+	return normalizeOpenIdScopes(config.Get().Auth.OpenId.Scopes)
+}
 
-	isOpenIdScopePresent := false
+// normalizeOpenIdScopes makes sure the mandatory "openid" scope is present in scopes, appending it
+// if it is missing. This is shared by GetConfiguredOpenIdScopes and ConfiguredSsoIssuers, so that a
+// multi-issuer config (Auth.OpenId.Issuers) cannot silently end up with an oauth2.Config missing
+// the mandatory scope just because a particular issuer's entry forgot to list it.
+func normalizeOpenIdScopes(scopes []string) []string {
 	for _, s := range scopes {
 		if s == "openid" {
-			isOpenIdScopePresent = true
-			break
+			return scopes
 		}
 	}
-
-	if !isOpenIdScopePresent {
-		scopes = append(scopes, "openid")
-	}
-
-	return scopes
+	return append(scopes, "openid")
 }
 
 // GetOpenIdMetadata fetches the OpenId metadata using the configured Issuer URI and
 // downloading the metadata from the well-known path '/.well-known/openid-configuration'. Some
-// validations are performed and the parsed metadata is returned. Since the metadata should be
-// rare to change, the retrieved metadata is cached on first call and subsequent calls return
-// the cached metadata.
-func GetOpenIdMetadata() (*OpenIdMetadata, error) {
-	if cachedOpenIdMetadata != nil {
-		return cachedOpenIdMetadata, nil
-	}
-
-	cfg := config.Get().Auth.OpenId
+// validations are performed and the parsed metadata is returned. Callers that need the metadata
+// to be verified and cached across several calls (e.g. to verify ID tokens) should instead obtain
+// a Provider via NewOpenIdProvider, which caches its own metadata and JWKS internally.
+func GetOpenIdMetadata(ctx context.Context) (*OpenIdMetadata, error) {
+	return fetchOpenIdMetadata(ctx, config.Get().Auth.OpenId)
+}
 
+// fetchOpenIdMetadata downloads and validates the OpenId metadata of the IdP described by cfg.
+// It performs no caching; it is the building block used both by GetOpenIdMetadata and by Provider.
+func fetchOpenIdMetadata(ctx context.Context, cfg config.OpenIdConfig) (*OpenIdMetadata, error) {
 	// Remove trailing slash from issuer URI, if needed
 	trimmedIssuerUri := strings.TrimRight(cfg.IssuerUri, "/")
 
@@ -80,7 +79,12 @@ func GetOpenIdMetadata() (*OpenIdMetadata, error) {
 	}
 
 	// Fetch IdP metadata
-	response, err := httpClient.Get(trimmedIssuerUri + "/.well-known/openid-configuration")
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, trimmedIssuerUri+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := httpClient.Do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -139,6 +143,5 @@ func GetOpenIdMetadata() (*OpenIdMetadata, error) {
 	}
 
 	// Return parsed metadata
-	cachedOpenIdMetadata = &metadata
-	return cachedOpenIdMetadata, nil
+	return &metadata, nil
 }
@@ -0,0 +1,181 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/oauth2"
+
+	"github.com/kiali/kiali/config"
+)
+
+// SsoIssuer describes a single OpenId Connect IdP Kiali can federate with. Namespace is only used
+// to disambiguate issuers that are managed as Kubernetes resources (e.g. for dynamic client
+// registration); it is empty for issuers configured purely through Kiali's static config.
+type SsoIssuer struct {
+	Name         string
+	Namespace    string
+	Addr         string
+	ClientId     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// SsoIssuerInfo is the subset of an SsoIssuer that is safe to expose to the login page so users
+// can pick which IdP to authenticate against.
+type SsoIssuerInfo struct {
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace"`
+	DisplayName string `json:"display_name"`
+}
+
+// resolvedIssuer bundles an SsoIssuer with the Provider and oauth2.Config resolved for it on
+// first use.
+type resolvedIssuer struct {
+	provider *Provider
+	oauth2   *oauth2.Config
+}
+
+// IssuerRegistry resolves and caches, per issuer name, the metadata/JWKS (via Provider) and
+// oauth2.Config of every configured SsoIssuer. This replaces the old package-level
+// cachedOpenIdMetadata singleton, which could only ever hold a single IdP: each registry owns its
+// own cache, so Kiali can be federated with several IdPs at once and tests can build an isolated
+// registry per case.
+type IssuerRegistry struct {
+	mu       sync.Mutex
+	issuers  map[string]SsoIssuer
+	resolved map[string]*resolvedIssuer
+}
+
+// NewIssuerRegistry builds a registry out of the given issuers, keyed by their Name.
+func NewIssuerRegistry(issuers []SsoIssuer) *IssuerRegistry {
+	byName := make(map[string]SsoIssuer, len(issuers))
+	for _, issuer := range issuers {
+		byName[issuer.Name] = issuer
+	}
+
+	return &IssuerRegistry{
+		issuers:  byName,
+		resolved: map[string]*resolvedIssuer{},
+	}
+}
+
+// ConfiguredSsoIssuers reads config.Get().Auth.OpenId and returns the list of configured issuers.
+// When Auth.OpenId.Issuers is set it is used, with the mandatory "openid" scope added to any
+// issuer whose scopes lack it; otherwise the legacy single-issuer fields
+// (IssuerUri, ClientId, ...) are wrapped into a single issuer named "default", so existing
+// single-IdP configurations keep working unchanged.
+func ConfiguredSsoIssuers() []SsoIssuer {
+	cfg := config.Get().Auth.OpenId
+
+	if len(cfg.Issuers) > 0 {
+		issuers := make([]SsoIssuer, 0, len(cfg.Issuers))
+		for _, configured := range cfg.Issuers {
+			issuers = append(issuers, SsoIssuer{
+				Name:         configured.Name,
+				Namespace:    configured.Namespace,
+				Addr:         configured.IssuerUri,
+				ClientId:     configured.ClientId,
+				ClientSecret: configured.ClientSecret,
+				Scopes:       normalizeOpenIdScopes(configured.Scopes),
+			})
+		}
+		return issuers
+	}
+
+	return []SsoIssuer{
+		{
+			Name:         "default",
+			Addr:         cfg.IssuerUri,
+			ClientId:     cfg.ClientId,
+			ClientSecret: cfg.ClientSecret,
+			Scopes:       GetConfiguredOpenIdScopes(),
+		},
+	}
+}
+
+// Fetch resolves the issuer named name, fetching its metadata and building its oauth2.Config on
+// first use, and returns the resulting Provider. Subsequent calls for the same name return the
+// cached Provider. This is the multi-issuer replacement for the old GetOpenIdMetadata.
+func (r *IssuerRegistry) Fetch(ctx context.Context, name string) (*Provider, error) {
+	r.mu.Lock()
+	cached, alreadyResolved := r.resolved[name]
+	issuer, known := r.issuers[name]
+	r.mu.Unlock()
+
+	if alreadyResolved {
+		return cached.provider, nil
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown SSO issuer %q", name)
+	}
+
+	// Resolve outside of r.mu: discovery is a network round-trip, and issuers share no state, so a
+	// slow or unreachable IdP must not block logins against other, unrelated issuers.
+	cfg := config.OpenIdConfig{
+		IssuerUri:             issuer.Addr,
+		ClientId:              issuer.ClientId,
+		InsecureSkipVerifyTLS: config.Get().Auth.OpenId.InsecureSkipVerifyTLS,
+		Scopes:                issuer.Scopes,
+	}
+
+	provider, err := NewOpenIdProvider(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve SSO issuer %q: %s", name, err.Error())
+	}
+
+	resolved := &resolvedIssuer{
+		provider: provider,
+		oauth2: &oauth2.Config{
+			ClientID:     issuer.ClientId,
+			ClientSecret: issuer.ClientSecret,
+			Scopes:       issuer.Scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   provider.Metadata().AuthURL,
+				TokenURL:  provider.Metadata().TokenURL,
+				AuthStyle: oauth2.AuthStyleInHeader,
+			},
+		},
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Another goroutine may have resolved the same issuer concurrently; keep whichever won the
+	// race so all callers converge on a single Provider/oauth2.Config pair.
+	if cached, ok := r.resolved[name]; ok {
+		return cached.provider, nil
+	}
+	r.resolved[name] = resolved
+	return provider, nil
+}
+
+// OAuth2Config returns the *oauth2.Config resolved for the issuer named name. Fetch must have
+// already been called for that issuer.
+func (r *IssuerRegistry) OAuth2Config(name string) (*oauth2.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	resolved, ok := r.resolved[name]
+	if !ok {
+		return nil, fmt.Errorf("SSO issuer %q has not been resolved yet; call Fetch first", name)
+	}
+	return resolved.oauth2, nil
+}
+
+// List returns the public description of every configured issuer, for a login page that lets
+// users pick which IdP to authenticate against.
+func (r *IssuerRegistry) List() []SsoIssuerInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := make([]SsoIssuerInfo, 0, len(r.issuers))
+	for _, issuer := range r.issuers {
+		infos = append(infos, SsoIssuerInfo{
+			Name:        issuer.Name,
+			Namespace:   issuer.Namespace,
+			DisplayName: issuer.Name,
+		})
+	}
+	return infos
+}
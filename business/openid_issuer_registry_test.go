@@ -0,0 +1,100 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiali/kiali/config"
+)
+
+func newDiscoveryServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OpenIdMetadata{
+			Issuer:     server.URL,
+			AuthURL:    server.URL + "/auth",
+			TokenURL:   server.URL + "/token",
+			JWKSURL:    server.URL + "/jwks",
+			Algorithms: []string{"RS256"},
+		})
+	}))
+	return server
+}
+
+func TestFetchConcurrentCallsConvergeOnSameProvider(t *testing.T) {
+	server := newDiscoveryServer(t)
+	defer server.Close()
+
+	registry := NewIssuerRegistry([]SsoIssuer{
+		{Name: "default", Addr: server.URL, ClientId: "client-1", Scopes: []string{"openid"}},
+	})
+
+	const goroutines = 10
+	providers := make([]*Provider, goroutines)
+	errs := make([]error, goroutines)
+
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		i := i
+		go func() {
+			defer wg.Done()
+			providers[i], errs[i] = registry.Fetch(context.Background(), "default")
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < goroutines; i++ {
+		require.NoError(t, errs[i])
+		assert.Same(t, providers[0], providers[i], "all concurrent Fetch calls must converge on the same resolved Provider")
+	}
+}
+
+func TestFetchUnknownIssuer(t *testing.T) {
+	registry := NewIssuerRegistry(nil)
+
+	_, err := registry.Fetch(context.Background(), "does-not-exist")
+	assert.Error(t, err)
+}
+
+func TestConfiguredSsoIssuersUsesIssuersListWhenSet(t *testing.T) {
+	config.Set(&config.Config{Auth: config.AuthConfig{OpenId: config.OpenIdConfig{
+		Issuers: []config.OpenIdConfigIssuer{
+			{Name: "first", IssuerUri: "https://first.example.com", ClientId: "client-1", Scopes: []string{"profile"}},
+		},
+	}}})
+	defer config.Set(&config.Config{})
+
+	issuers := ConfiguredSsoIssuers()
+
+	require.Len(t, issuers, 1)
+	assert.Equal(t, "first", issuers[0].Name)
+	assert.Contains(t, issuers[0].Scopes, "openid", "the mandatory openid scope must be added even when the issuer's own list omits it")
+}
+
+func TestConfiguredSsoIssuersFallsBackToLegacySingleIssuer(t *testing.T) {
+	config.Set(&config.Config{Auth: config.AuthConfig{OpenId: config.OpenIdConfig{
+		IssuerUri:    "https://legacy.example.com",
+		ClientId:     "legacy-client",
+		ClientSecret: "legacy-secret",
+		Scopes:       []string{"profile"},
+	}}})
+	defer config.Set(&config.Config{})
+
+	issuers := ConfiguredSsoIssuers()
+
+	require.Len(t, issuers, 1)
+	assert.Equal(t, "default", issuers[0].Name)
+	assert.Equal(t, "https://legacy.example.com", issuers[0].Addr)
+	assert.Equal(t, "legacy-client", issuers[0].ClientId)
+	assert.Contains(t, issuers[0].Scopes, "openid")
+}
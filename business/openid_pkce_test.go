@@ -0,0 +1,52 @@
+package business
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCodeVerifier(t *testing.T) {
+	verifier, err := NewCodeVerifier()
+	require.NoError(t, err)
+	assert.Len(t, verifier, codeVerifierLength)
+
+	for _, c := range verifier {
+		assert.Contains(t, codeVerifierAlphabet, string(c))
+	}
+}
+
+func TestNewCodeChallengePrefersS256(t *testing.T) {
+	verifier := "a-code-verifier"
+
+	challenge, method, err := NewCodeChallenge([]string{"plain", "S256"}, verifier)
+	require.NoError(t, err)
+	assert.Equal(t, CodeChallengeMethodS256, method)
+
+	sum := sha256.Sum256([]byte(verifier))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:]), challenge)
+}
+
+func TestNewCodeChallengeFallsBackToPlain(t *testing.T) {
+	verifier := "a-code-verifier"
+
+	challenge, method, err := NewCodeChallenge([]string{"plain"}, verifier)
+	require.NoError(t, err)
+	assert.Equal(t, CodeChallengeMethodPlain, method)
+	assert.Equal(t, verifier, challenge)
+}
+
+func TestNewCodeChallengeNoSupportedMethod(t *testing.T) {
+	_, _, err := NewCodeChallenge([]string{"unknown-method"}, "a-code-verifier")
+	assert.ErrorIs(t, err, ErrNoPkceSupport)
+}
+
+func TestValidateCodeChallengeMethod(t *testing.T) {
+	metadata := &OpenIdMetadata{CodeChallengeMethodsSupported: []string{"S256"}}
+
+	assert.NoError(t, ValidateCodeChallengeMethod(metadata, "S256"))
+	assert.ErrorIs(t, ValidateCodeChallengeMethod(metadata, "plain"), ErrNoPkceSupport)
+}
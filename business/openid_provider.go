@@ -0,0 +1,505 @@
+package business
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/kiali/kiali/config"
+)
+
+// minJwksResyncWindow bounds how often Provider is willing to re-fetch the JWKS document in
+// response to an unknown "kid" showing up on an ID token. Without this, a forged "kid" could be
+// used to make Kiali hammer the IdP's JWKS endpoint.
+const minJwksResyncWindow = 5 * time.Second
+
+// Provider wraps the OpenId metadata and JWKS of a single IdP and offers ID token verification
+// and UserInfo retrieval, analogous to go-oidc's Provider/IDTokenVerifier. Unlike the package-level
+// GetOpenIdMetadata helper, a Provider caches its metadata and keys for its own lifetime, so tests
+// and each issuer of an IssuerRegistry can hold an isolated instance.
+type Provider struct {
+	metadata   *OpenIdMetadata
+	httpClient *http.Client
+	clientId   string
+
+	keysMu        sync.RWMutex
+	keysByKid     map[string]crypto.PublicKey
+	lastJwksFetch time.Time
+
+	// refreshMu serializes JWKS refreshes without making cached lookups (which only take keysMu's
+	// read lock) wait on an in-flight refresh.
+	refreshMu sync.Mutex
+}
+
+// jsonWebKeySet is the body of the document served at the IdP's jwks_uri.
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// jsonWebKey holds the subset of RFC 7517 fields Kiali needs to reconstruct an RSA or EC public key.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwtHeader is the decoded JOSE header of an ID token.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// IDToken is the result of successfully verifying an ID token: its well-known claims plus the raw
+// claim set for callers that need to read provider-specific claims.
+type IDToken struct {
+	Issuer   string
+	Audience []string
+	Subject  string
+	Expiry   time.Time
+	IssuedAt time.Time
+	Nonce    string
+	Azp      string
+
+	header jwtHeader
+	claims map[string]interface{}
+}
+
+// Claims unmarshals the ID token's claim set into v, following the same convention as
+// encoding/json.Unmarshal.
+func (t *IDToken) Claims(v interface{}) error {
+	raw, err := json.Marshal(t.claims)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// UserInfo is the response of the IdP's userinfo_endpoint.
+type UserInfo struct {
+	Subject       string `json:"sub"`
+	Profile       string `json:"profile"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// verifyConfig holds the options accumulated from the VerifierOptions passed to Provider.Verify.
+type verifyConfig struct {
+	nonce string
+}
+
+// VerifierOption customizes a single call to Provider.Verify.
+type VerifierOption func(*verifyConfig)
+
+// Nonce makes Verify reject any ID token whose "nonce" claim does not match expected. Callers
+// should always pass this when verifying tokens obtained from the authorization code or implicit
+// flows, using the nonce value stored in the request's state cookie.
+func (p *Provider) Nonce(expected string) VerifierOption {
+	return func(c *verifyConfig) {
+		c.nonce = expected
+	}
+}
+
+// NewOpenIdProvider fetches the metadata of the IdP described by cfg and returns a Provider ready
+// to verify ID tokens issued by it. The returned Provider keeps its own metadata and JWKS cache;
+// construct one per configured issuer and re-use it across requests.
+func NewOpenIdProvider(ctx context.Context, cfg config.OpenIdConfig) (*Provider, error) {
+	metadata, err := fetchOpenIdMetadata(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metadata.JWKSURL) == 0 {
+		return nil, errors.New("the OpenID provider does not expose a jwks_uri")
+	}
+
+	httpTransport := &http.Transport{}
+	if cfg.InsecureSkipVerifyTLS {
+		httpTransport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
+		}
+	}
+
+	return &Provider{
+		metadata: metadata,
+		httpClient: &http.Client{
+			Timeout:   time.Second * 10,
+			Transport: httpTransport,
+		},
+		clientId:  cfg.ClientId,
+		keysByKid: map[string]crypto.PublicKey{},
+	}, nil
+}
+
+// Metadata returns the OpenId metadata this Provider was constructed with.
+func (p *Provider) Metadata() *OpenIdMetadata {
+	return p.metadata
+}
+
+// Verify checks the signature and standard claims (iss, aud, exp, nbf, iat) of rawIDToken and
+// returns its parsed claims. The signing algorithm is read from the token's JOSE header and is
+// rejected unless it is one of the algorithms the IdP advertised in its metadata.
+func (p *Provider) Verify(ctx context.Context, rawIDToken string, opts ...VerifierOption) (*IDToken, error) {
+	cfg := verifyConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token: expected three dot-separated parts")
+	}
+
+	header, err := decodeJwtHeader(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode ID token header: %s", err.Error())
+	}
+
+	if !containsString(p.metadata.Algorithms, header.Alg) {
+		return nil, fmt.Errorf("ID token is signed with alg %q, which the OpenID provider does not advertise as supported", header.Alg)
+	}
+
+	key, err := p.publicKey(ctx, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve signing key %q: %s", header.Kid, err.Error())
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode ID token signature: %s", err.Error())
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+	if err := verifySignature(key, header.Alg, []byte(signingInput), signature); err != nil {
+		return nil, fmt.Errorf("ID token signature verification failed: %s", err.Error())
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode ID token claims: %s", err.Error())
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(rawClaims, &claims); err != nil {
+		return nil, fmt.Errorf("cannot parse ID token claims: %s", err.Error())
+	}
+
+	idToken, err := newIdToken(header, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if idToken.Issuer != p.metadata.Issuer {
+		return nil, fmt.Errorf("ID token issuer %q does not match the OpenID provider issuer %q", idToken.Issuer, p.metadata.Issuer)
+	}
+	if !containsString(idToken.Audience, p.clientId) {
+		return nil, fmt.Errorf("ID token audience does not contain the configured client_id %q", p.clientId)
+	}
+	// Per OIDC Core ?3.1.3.7 steps 9-10, an ID token issued for more than one audience must carry an
+	// "azp" claim identifying the party it was actually issued to, which must match our client_id;
+	// otherwise a token meant for a different relying party sharing this IdP could be replayed here.
+	if len(idToken.Audience) > 1 && idToken.Azp != p.clientId {
+		return nil, fmt.Errorf("ID token has multiple audiences but azp %q does not match the configured client_id %q", idToken.Azp, p.clientId)
+	}
+
+	now := time.Now()
+	if now.After(idToken.Expiry) {
+		return nil, fmt.Errorf("ID token is expired (exp = %s)", idToken.Expiry)
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		if nbfTime, err := toTime(nbf); err == nil && now.Before(nbfTime) {
+			return nil, fmt.Errorf("ID token is not valid yet (nbf = %s)", nbfTime)
+		}
+	}
+
+	if cfg.nonce != "" && idToken.Nonce != cfg.nonce {
+		return nil, errors.New("ID token nonce does not match the expected value")
+	}
+
+	return idToken, nil
+}
+
+// UserInfo calls the IdP's userinfo_endpoint using the access token produced by tokenSource and
+// returns the parsed response.
+func (p *Provider) UserInfo(ctx context.Context, tokenSource oauth2.TokenSource) (*UserInfo, error) {
+	if len(p.metadata.UserInfoURL) == 0 {
+		return nil, errors.New("the OpenID provider does not expose a userinfo_endpoint")
+	}
+
+	token, err := tokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain an access token to call the userinfo endpoint: %s", err.Error())
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.metadata.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	token.SetAuthHeader(request)
+
+	response, err := p.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot fetch UserInfo (HTTP response status = %s)", response.Status)
+	}
+
+	rawBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read UserInfo response: %s", err.Error())
+	}
+
+	var userInfo UserInfo
+	if err := json.Unmarshal(rawBody, &userInfo); err != nil {
+		return nil, fmt.Errorf("cannot parse UserInfo response: %s", err.Error())
+	}
+
+	return &userInfo, nil
+}
+
+// publicKey returns the public key for kid, fetching (or re-fetching) the JWKS document as needed.
+// A re-fetch is only attempted when kid is unknown and at least minJwksResyncWindow has elapsed
+// since the last fetch, so a malicious or buggy "kid" cannot be used to flood the IdP. Cached
+// lookups only take a read lock, so a concurrent refresh never stalls a Verify() call whose kid is
+// already known.
+func (p *Provider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := p.cachedKey(kid); ok {
+		return key, nil
+	}
+
+	// Refreshes are serialized on refreshMu, not keysMu, so readers of the existing cache are
+	// never blocked behind an in-flight JWKS fetch.
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	// Another goroutine may have refreshed the JWKS while we were waiting for refreshMu.
+	if key, ok := p.cachedKey(kid); ok {
+		return key, nil
+	}
+
+	p.keysMu.RLock()
+	lastFetch := p.lastJwksFetch
+	p.keysMu.RUnlock()
+	if !lastFetch.IsZero() && time.Since(lastFetch) < minJwksResyncWindow {
+		return nil, fmt.Errorf("unknown signing key %q and the JWKS re-sync window has not elapsed yet", kid)
+	}
+
+	if err := p.refreshJwks(ctx); err != nil {
+		return nil, err
+	}
+
+	key, ok := p.cachedKey(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q after re-fetching the JWKS document", kid)
+	}
+	return key, nil
+}
+
+// cachedKey looks up kid under a read lock, without ever blocking on a concurrent refresh.
+func (p *Provider) cachedKey(kid string) (crypto.PublicKey, bool) {
+	p.keysMu.RLock()
+	defer p.keysMu.RUnlock()
+	key, ok := p.keysByKid[kid]
+	return key, ok
+}
+
+// refreshJwks downloads the JWKS document and replaces the cached keys. Callers must hold
+// p.refreshMu so concurrent refreshes are serialized.
+func (p *Provider) refreshJwks(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.metadata.JWKSURL, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := p.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot fetch JWKS (HTTP response status = %s)", response.Status)
+	}
+
+	rawBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS document: %s", err.Error())
+	}
+
+	var jwks jsonWebKeySet
+	if err := json.Unmarshal(rawBody, &jwks); err != nil {
+		return fmt.Errorf("cannot parse JWKS document: %s", err.Error())
+	}
+
+	keysByKid := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			return fmt.Errorf("cannot parse key %q in JWKS document: %s", jwk.Kid, err.Error())
+		}
+		keysByKid[jwk.Kid] = key
+	}
+
+	p.keysMu.Lock()
+	p.keysByKid = keysByKid
+	p.lastJwksFetch = time.Now()
+	p.keysMu.Unlock()
+	return nil
+}
+
+// publicKey reconstructs the crypto.PublicKey described by the JWK.
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %s", err.Error())
+		}
+		eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %s", err.Error())
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(nBytes),
+			E: int(new(big.Int).SetBytes(eBytes).Int64()),
+		}, nil
+	case "EC":
+		curve, err := ecCurve(k.Crv)
+		if err != nil {
+			return nil, err
+		}
+		xBytes, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %s", err.Error())
+		}
+		yBytes, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %s", err.Error())
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(xBytes),
+			Y:     new(big.Int).SetBytes(yBytes),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// newIdToken extracts the well-known claims of an ID token into an *IDToken.
+func newIdToken(header jwtHeader, claims map[string]interface{}) (*IDToken, error) {
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	nonce, _ := claims["nonce"].(string)
+	azp, _ := claims["azp"].(string)
+
+	audience, err := toStringSlice(claims["aud"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token audience: %s", err.Error())
+	}
+
+	expiry, err := toTime(claims["exp"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token expiry: %s", err.Error())
+	}
+
+	var issuedAt time.Time
+	if iat, ok := claims["iat"]; ok {
+		issuedAt, err = toTime(iat)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID token issued-at: %s", err.Error())
+		}
+	}
+
+	return &IDToken{
+		Issuer:   issuer,
+		Audience: audience,
+		Subject:  subject,
+		Expiry:   expiry,
+		IssuedAt: issuedAt,
+		Nonce:    nonce,
+		Azp:      azp,
+		header:   header,
+		claims:   claims,
+	}, nil
+}
+
+func decodeJwtHeader(rawHeader string) (jwtHeader, error) {
+	var header jwtHeader
+
+	decoded, err := base64.RawURLEncoding.DecodeString(rawHeader)
+	if err != nil {
+		return header, err
+	}
+
+	err = json.Unmarshal(decoded, &header)
+	return header, err
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// toStringSlice converts the "aud" claim, which per RFC 7519 may be a single string or an array
+// of strings, into a []string.
+func toStringSlice(v interface{}) ([]string, error) {
+	switch value := v.(type) {
+	case string:
+		return []string{value}, nil
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string, got %T", item)
+			}
+			result = append(result, s)
+		}
+		return result, nil
+	case nil:
+		return nil, errors.New("claim is missing")
+	default:
+		return nil, fmt.Errorf("expected a string or an array of strings, got %T", v)
+	}
+}
+
+// toTime converts a JSON numeric "seconds since epoch" claim (e.g. "exp", "iat", "nbf") into a time.Time.
+func toTime(v interface{}) (time.Time, error) {
+	seconds, ok := v.(float64)
+	if !ok {
+		return time.Time{}, fmt.Errorf("expected a number, got %T", v)
+	}
+	return time.Unix(int64(seconds), 0), nil
+}
@@ -0,0 +1,227 @@
+package business
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signToken base64url-encodes header and claims and appends a signature computed by sign over the
+// resulting "header.payload", mirroring how Provider.Verify reconstructs signingInput.
+func signToken(t *testing.T, header, claims map[string]interface{}, sign func(signingInput []byte) []byte) string {
+	t.Helper()
+
+	rawHeader, err := json.Marshal(header)
+	require.NoError(t, err)
+	rawClaims, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(rawHeader) + "." + base64.RawURLEncoding.EncodeToString(rawClaims)
+	sig := sign([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func rsaSigner(t *testing.T, key *rsa.PrivateKey) func([]byte) []byte {
+	t.Helper()
+	return func(signingInput []byte) []byte {
+		digest := sha256.Sum256(signingInput)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+		return sig
+	}
+}
+
+func ecSigner(t *testing.T, key *ecdsa.PrivateKey) func([]byte) []byte {
+	t.Helper()
+	return func(signingInput []byte) []byte {
+		digest := sha256.Sum256(signingInput)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		require.NoError(t, err)
+
+		keyBytes := (key.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*keyBytes)
+		r.FillBytes(sig[:keyBytes])
+		s.FillBytes(sig[keyBytes:])
+		return sig
+	}
+}
+
+func newTestProvider(t *testing.T, alg string, keysByKid map[string]crypto.PublicKey) *Provider {
+	t.Helper()
+	return &Provider{
+		metadata: &OpenIdMetadata{
+			Issuer:     "https://idp.example.com",
+			Algorithms: []string{alg},
+		},
+		httpClient: http.DefaultClient,
+		clientId:   "test-client",
+		keysByKid:  keysByKid,
+	}
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": "https://idp.example.com",
+		"aud": "test-client",
+		"sub": "user-1",
+		"exp": float64(now.Add(time.Hour).Unix()),
+		"iat": float64(now.Unix()),
+	}
+}
+
+func TestVerifyMultipleAudiencesRequiresMatchingAzp(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	provider := newTestProvider(t, "RS256", map[string]crypto.PublicKey{"kid1": &key.PublicKey})
+	claims := validClaims()
+	claims["aud"] = []interface{}{"test-client", "other-client"}
+	claims["azp"] = "test-client"
+	token := signToken(t, map[string]interface{}{"alg": "RS256", "kid": "kid1"}, claims, rsaSigner(t, key))
+
+	idToken, err := provider.Verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, "test-client", idToken.Azp)
+}
+
+func TestVerifyMultipleAudiencesRejectsMismatchedAzp(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	provider := newTestProvider(t, "RS256", map[string]crypto.PublicKey{"kid1": &key.PublicKey})
+	claims := validClaims()
+	claims["aud"] = []interface{}{"test-client", "other-client"}
+	claims["azp"] = "other-client"
+	token := signToken(t, map[string]interface{}{"alg": "RS256", "kid": "kid1"}, claims, rsaSigner(t, key))
+
+	_, err = provider.Verify(context.Background(), token)
+	assert.Error(t, err)
+}
+
+func TestVerifyMultipleAudiencesRejectsMissingAzp(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	provider := newTestProvider(t, "RS256", map[string]crypto.PublicKey{"kid1": &key.PublicKey})
+	claims := validClaims()
+	claims["aud"] = []interface{}{"test-client", "other-client"}
+	token := signToken(t, map[string]interface{}{"alg": "RS256", "kid": "kid1"}, claims, rsaSigner(t, key))
+
+	_, err = provider.Verify(context.Background(), token)
+	assert.Error(t, err)
+}
+
+func TestVerifyRS256Success(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	provider := newTestProvider(t, "RS256", map[string]crypto.PublicKey{"kid1": &key.PublicKey})
+	token := signToken(t, map[string]interface{}{"alg": "RS256", "kid": "kid1"}, validClaims(), rsaSigner(t, key))
+
+	idToken, err := provider.Verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", idToken.Subject)
+}
+
+func TestVerifyES256Success(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	provider := newTestProvider(t, "ES256", map[string]crypto.PublicKey{"kid1": &key.PublicKey})
+	token := signToken(t, map[string]interface{}{"alg": "ES256", "kid": "kid1"}, validClaims(), ecSigner(t, key))
+
+	idToken, err := provider.Verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", idToken.Subject)
+}
+
+func TestVerifyRejectsTamperedClaims(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	provider := newTestProvider(t, "RS256", map[string]crypto.PublicKey{"kid1": &key.PublicKey})
+	token := signToken(t, map[string]interface{}{"alg": "RS256", "kid": "kid1"}, validClaims(), rsaSigner(t, key))
+
+	// Swap in different claims while keeping the original header and signature.
+	tamperedClaims := validClaims()
+	tamperedClaims["sub"] = "user-2"
+	rawTamperedClaims, err := json.Marshal(tamperedClaims)
+	require.NoError(t, err)
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(rawTamperedClaims) + "." + parts[2]
+
+	_, err = provider.Verify(context.Background(), tampered)
+	assert.Error(t, err)
+}
+
+func TestVerifyRejectsTamperedSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	provider := newTestProvider(t, "RS256", map[string]crypto.PublicKey{"kid1": &key.PublicKey})
+	// Sign with a key that doesn't match the one registered under "kid1".
+	token := signToken(t, map[string]interface{}{"alg": "RS256", "kid": "kid1"}, validClaims(), rsaSigner(t, otherKey))
+
+	_, err = provider.Verify(context.Background(), token)
+	assert.Error(t, err)
+}
+
+func TestPublicKeyUnknownKidWithinResyncWindowFailsFast(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	provider := newTestProvider(t, "RS256", map[string]crypto.PublicKey{})
+	provider.metadata.JWKSURL = server.URL
+	provider.lastJwksFetch = time.Now()
+
+	_, err := provider.publicKey(context.Background(), "unknown-kid")
+	assert.Error(t, err)
+	assert.Equal(t, 0, calls, "should not re-fetch the JWKS within minJwksResyncWindow")
+}
+
+func TestPublicKeyUnknownKidWithoutPriorFetchRefreshes(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwk := jsonWebKey{
+			Kty: "RSA",
+			Kid: "kid1",
+			N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}
+		body, _ := json.Marshal(jsonWebKeySet{Keys: []jsonWebKey{jwk}})
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	provider := newTestProvider(t, "RS256", map[string]crypto.PublicKey{})
+	provider.metadata.JWKSURL = server.URL
+	// No lastJwksFetch yet, so the resync window does not block the first refresh.
+
+	resolved, err := provider.publicKey(context.Background(), "kid1")
+	require.NoError(t, err)
+	assert.Equal(t, key.PublicKey.N, resolved.(*rsa.PublicKey).N)
+}
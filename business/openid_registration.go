@@ -0,0 +1,280 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kiali/kiali/config"
+	"github.com/kiali/kiali/kubernetes"
+)
+
+// openIdCallbackPath is the path Kiali expects the IdP to redirect back to once the user has
+// authenticated, relative to Kiali's external URL.
+const openIdCallbackPath = "/api/auth/openid/callback"
+
+// ClientRegistrationRequest is the RFC 7591 client-metadata document Kiali sends to the IdP's
+// registration_endpoint to self-register as an OpenId Connect client.
+type ClientRegistrationRequest struct {
+	RedirectURIs            []string `json:"redirect_uris"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	ApplicationType         string   `json:"application_type,omitempty"`
+
+	// ClientId and ClientSecret are only set by RefreshClientRegistration: RFC 7592 ?4.2 requires a
+	// Client Update Request to echo the client's own client_id (and, per most IdPs, client_secret)
+	// back in the body. They are left empty for the initial RFC 7591 registration request, which
+	// has no client_id yet.
+	ClientId     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// ClientRegistrationResponse is the RFC 7591 response returned by the IdP once registration
+// succeeds, including the credentials Kiali must henceforth use to authenticate its own requests.
+type ClientRegistrationResponse struct {
+	ClientId                string `json:"client_id"`
+	ClientSecret             string `json:"client_secret,omitempty"`
+	ClientSecretExpiresAt    int64  `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
+}
+
+// NewClientRegistrationRequest builds the client-metadata document Kiali will register with the
+// IdP, deriving the redirect_uris from kialiExternalURL and the response/grant types from the
+// response type Kiali is configured to use.
+func NewClientRegistrationRequest(kialiExternalURL string, cfg config.OpenIdConfig) ClientRegistrationRequest {
+	responseType := cfg.ResponseType
+	if responseType == "" {
+		responseType = "code"
+	}
+
+	grantTypes := []string{"authorization_code"}
+	if responseType == "id_token" || responseType == "id_token token" {
+		grantTypes = []string{"implicit"}
+	}
+
+	return ClientRegistrationRequest{
+		RedirectURIs:            []string{strings.TrimRight(kialiExternalURL, "/") + openIdCallbackPath},
+		ResponseTypes:           []string{responseType},
+		GrantTypes:              grantTypes,
+		TokenEndpointAuthMethod: "client_secret_basic",
+		ApplicationType:         "web",
+	}
+}
+
+// RegisterOpenIdClient performs RFC 7591 Dynamic Client Registration of Kiali against the IdP
+// described by metadata, using request as the client-metadata document. It returns the
+// credentials assigned by the IdP, which callers are expected to persist (see
+// PersistClientRegistration) since they cannot be retrieved again other than through the
+// registration_client_uri using the returned registration_access_token.
+func RegisterOpenIdClient(ctx context.Context, cfg config.OpenIdConfig, metadata *OpenIdMetadata, request ClientRegistrationRequest) (*ClientRegistrationResponse, error) {
+	if len(metadata.RegistrationEndpoint) == 0 {
+		return nil, errors.New("the OpenID provider does not expose a registration_endpoint")
+	}
+
+	rawRequest, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal client registration request: %s", err.Error())
+	}
+
+	return doClientRegistration(ctx, cfg, http.MethodPost, metadata.RegistrationEndpoint, "", rawRequest)
+}
+
+// RefreshClientRegistration re-registers Kiali at registration.RegistrationClientURI using the
+// previously issued registration_access_token, as required once client_secret_expires_at has
+// passed. The IdP is expected to return a fresh client_secret (and, possibly, a fresh
+// registration_access_token) which the caller must persist in place of the old registration.
+func RefreshClientRegistration(ctx context.Context, cfg config.OpenIdConfig, registration *ClientRegistrationResponse, request ClientRegistrationRequest) (*ClientRegistrationResponse, error) {
+	if len(registration.RegistrationClientURI) == 0 || len(registration.RegistrationAccessToken) == 0 {
+		return nil, errors.New("cannot refresh a client registration that has no registration_client_uri or registration_access_token")
+	}
+
+	request.ClientId = registration.ClientId
+	request.ClientSecret = registration.ClientSecret
+
+	rawRequest, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal client registration request: %s", err.Error())
+	}
+
+	return doClientRegistration(ctx, cfg, http.MethodPut, registration.RegistrationClientURI, registration.RegistrationAccessToken, rawRequest)
+}
+
+// IsClientRegistrationExpired tells whether registration's client_secret_expires_at has passed.
+// Per RFC 7591, a value of zero means the client_secret never expires.
+func IsClientRegistrationExpired(registration *ClientRegistrationResponse) bool {
+	if registration.ClientSecretExpiresAt == 0 {
+		return false
+	}
+	return time.Now().After(time.Unix(registration.ClientSecretExpiresAt, 0))
+}
+
+// EnsureClientRegistration returns a client registration Kiali can use to talk to the IdP
+// described by metadata, reading it from the Kubernetes Secret namespace/secretName and
+// performing RFC 7591 Dynamic Client Registration (or re-registration, once the client_secret has
+// expired) as needed. It is a no-op returning (nil, nil) unless cfg.DynamicRegistration is set:
+// dynamic registration only kicks in when an operator has explicitly opted into it, since most
+// IdPs require registration to be requested out-of-band and a statically configured ClientId is
+// the common case.
+func EnsureClientRegistration(ctx context.Context, k8s kubernetes.ClientInterface, cfg config.OpenIdConfig, kialiExternalURL, namespace, secretName string, metadata *OpenIdMetadata) (*ClientRegistrationResponse, error) {
+	if !cfg.DynamicRegistration {
+		return nil, nil
+	}
+
+	request := NewClientRegistrationRequest(kialiExternalURL, cfg)
+
+	if _, err := k8s.Kube().CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{}); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("cannot read Secret %s/%s: %s", namespace, secretName, err.Error())
+		}
+
+		registration, err := RegisterOpenIdClient(ctx, cfg, metadata, request)
+		if err != nil {
+			return nil, fmt.Errorf("dynamic client registration failed: %s", err.Error())
+		}
+		if err := PersistClientRegistration(ctx, k8s, namespace, secretName, registration); err != nil {
+			return nil, fmt.Errorf("cannot persist client registration: %s", err.Error())
+		}
+		return registration, nil
+	}
+
+	existing, err := ReadClientRegistration(ctx, k8s, namespace, secretName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read existing client registration: %s", err.Error())
+	}
+
+	if !IsClientRegistrationExpired(existing) {
+		return existing, nil
+	}
+
+	refreshed, err := RefreshClientRegistration(ctx, cfg, existing, request)
+	if err != nil {
+		return nil, fmt.Errorf("cannot refresh expired client registration: %s", err.Error())
+	}
+	if err := PersistClientRegistration(ctx, k8s, namespace, secretName, refreshed); err != nil {
+		return nil, fmt.Errorf("cannot persist refreshed client registration: %s", err.Error())
+	}
+	return refreshed, nil
+}
+
+func doClientRegistration(ctx context.Context, cfg config.OpenIdConfig, method, url, bearerToken string, rawRequest []byte) (*ClientRegistrationResponse, error) {
+	httpRequest, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(rawRequest))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if bearerToken != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+bearerToken)
+	}
+
+	httpTransport := &http.Transport{}
+	if cfg.InsecureSkipVerifyTLS {
+		httpTransport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
+		}
+	}
+
+	httpClient := http.Client{Timeout: time.Second * 10, Transport: httpTransport}
+	response, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("client registration failed (HTTP response status = %s)", response.Status)
+	}
+
+	rawResponse, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client registration response: %s", err.Error())
+	}
+
+	var registration ClientRegistrationResponse
+	if err := json.Unmarshal(rawResponse, &registration); err != nil {
+		return nil, fmt.Errorf("cannot parse client registration response: %s", err.Error())
+	}
+
+	return &registration, nil
+}
+
+// Data keys used in the Kubernetes Secret that stores a dynamically-obtained client registration.
+const (
+	secretKeyClientId                = "client_id"
+	secretKeyClientSecret            = "client_secret"
+	secretKeyClientSecretExpiresAt   = "client_secret_expires_at"
+	secretKeyRegistrationAccessToken = "registration_access_token"
+	secretKeyRegistrationClientURI   = "registration_client_uri"
+)
+
+// PersistClientRegistration stores registration as a Kubernetes Secret named secretName in
+// namespace, creating it if it does not already exist or updating it otherwise.
+func PersistClientRegistration(ctx context.Context, k8s kubernetes.ClientInterface, namespace, secretName string, registration *ClientRegistrationResponse) error {
+	secrets := k8s.Kube().CoreV1().Secrets(namespace)
+
+	data := map[string][]byte{
+		secretKeyClientId:                []byte(registration.ClientId),
+		secretKeyClientSecret:            []byte(registration.ClientSecret),
+		secretKeyClientSecretExpiresAt:   []byte(strconv.FormatInt(registration.ClientSecretExpiresAt, 10)),
+		secretKeyRegistrationAccessToken: []byte(registration.RegistrationAccessToken),
+		secretKeyRegistrationClientURI:   []byte(registration.RegistrationClientURI),
+	}
+
+	existing, err := secrets.Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("cannot read Secret %s/%s: %s", namespace, secretName, err.Error())
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: namespace},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       data,
+		}
+		if _, err := secrets.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("cannot create Secret %s/%s: %s", namespace, secretName, err.Error())
+		}
+		return nil
+	}
+
+	existing.Data = data
+	if _, err := secrets.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("cannot update Secret %s/%s: %s", namespace, secretName, err.Error())
+	}
+	return nil
+}
+
+// ReadClientRegistration reads back a client registration previously stored by
+// PersistClientRegistration.
+func ReadClientRegistration(ctx context.Context, k8s kubernetes.ClientInterface, namespace, secretName string) (*ClientRegistrationResponse, error) {
+	secret, err := k8s.Kube().CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Secret %s/%s: %s", namespace, secretName, err.Error())
+	}
+
+	expiresAt, err := strconv.ParseInt(string(secret.Data[secretKeyClientSecretExpiresAt]), 10, 64)
+	if err != nil {
+		expiresAt = 0
+	}
+
+	return &ClientRegistrationResponse{
+		ClientId:                string(secret.Data[secretKeyClientId]),
+		ClientSecret:            string(secret.Data[secretKeyClientSecret]),
+		ClientSecretExpiresAt:   expiresAt,
+		RegistrationAccessToken: string(secret.Data[secretKeyRegistrationAccessToken]),
+		RegistrationClientURI:   string(secret.Data[secretKeyRegistrationClientURI]),
+	}, nil
+}
@@ -0,0 +1,142 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiali/kiali/config"
+	"github.com/kiali/kiali/kubernetes/kubetest"
+)
+
+func TestNewClientRegistrationRequestAuthorizationCode(t *testing.T) {
+	request := NewClientRegistrationRequest("https://kiali.example.com/", config.OpenIdConfig{ResponseType: "code"})
+
+	assert.Equal(t, []string{"https://kiali.example.com" + openIdCallbackPath}, request.RedirectURIs)
+	assert.Equal(t, []string{"code"}, request.ResponseTypes)
+	assert.Equal(t, []string{"authorization_code"}, request.GrantTypes)
+}
+
+func TestNewClientRegistrationRequestImplicit(t *testing.T) {
+	request := NewClientRegistrationRequest("https://kiali.example.com", config.OpenIdConfig{ResponseType: "id_token token"})
+
+	assert.Equal(t, []string{"implicit"}, request.GrantTypes)
+}
+
+func TestRegisterOpenIdClient(t *testing.T) {
+	var gotMethod, gotAuth string
+	var gotRequest ClientRegistrationRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotAuth = r.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&gotRequest))
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ClientRegistrationResponse{
+			ClientId:                "generated-client-id",
+			ClientSecret:            "generated-client-secret",
+			RegistrationAccessToken: "registration-token",
+			RegistrationClientURI:   "https://idp.example.com/register/generated-client-id",
+		})
+	}))
+	defer server.Close()
+
+	metadata := &OpenIdMetadata{RegistrationEndpoint: server.URL}
+	request := NewClientRegistrationRequest("https://kiali.example.com", config.OpenIdConfig{})
+
+	registration, err := RegisterOpenIdClient(context.Background(), config.OpenIdConfig{}, metadata, request)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.MethodPost, gotMethod)
+	assert.Empty(t, gotAuth, "the initial registration request has no registration_access_token to authenticate with")
+	assert.Empty(t, gotRequest.ClientId, "the initial registration request has no client_id yet")
+	assert.Equal(t, "generated-client-id", registration.ClientId)
+}
+
+func TestRegisterOpenIdClientNoRegistrationEndpoint(t *testing.T) {
+	_, err := RegisterOpenIdClient(context.Background(), config.OpenIdConfig{}, &OpenIdMetadata{}, ClientRegistrationRequest{})
+	assert.Error(t, err)
+}
+
+// TestRefreshClientRegistrationEchoesClientCredentials guards against the Client Update Request
+// silently dropping client_id/client_secret, which RFC 7592 ?4.2 requires and which real IdPs
+// (Keycloak, Auth0, ...) reject with invalid_client_metadata if missing.
+func TestRefreshClientRegistrationEchoesClientCredentials(t *testing.T) {
+	var gotMethod, gotAuth string
+	var gotRequest ClientRegistrationRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotAuth = r.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&gotRequest))
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ClientRegistrationResponse{
+			ClientId:                "existing-client-id",
+			ClientSecret:            "refreshed-client-secret",
+			RegistrationAccessToken: "registration-token",
+			RegistrationClientURI:   r.URL.String(),
+		})
+	}))
+	defer server.Close()
+
+	existing := &ClientRegistrationResponse{
+		ClientId:                "existing-client-id",
+		ClientSecret:            "existing-client-secret",
+		RegistrationAccessToken: "registration-token",
+		RegistrationClientURI:   server.URL,
+	}
+	request := NewClientRegistrationRequest("https://kiali.example.com", config.OpenIdConfig{})
+
+	refreshed, err := RefreshClientRegistration(context.Background(), config.OpenIdConfig{}, existing, request)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.MethodPut, gotMethod)
+	assert.Equal(t, "Bearer registration-token", gotAuth)
+	assert.Equal(t, "existing-client-id", gotRequest.ClientId)
+	assert.Equal(t, "existing-client-secret", gotRequest.ClientSecret)
+	assert.Equal(t, "refreshed-client-secret", refreshed.ClientSecret)
+}
+
+func TestRefreshClientRegistrationRequiresExistingRegistration(t *testing.T) {
+	_, err := RefreshClientRegistration(context.Background(), config.OpenIdConfig{}, &ClientRegistrationResponse{}, ClientRegistrationRequest{})
+	assert.Error(t, err)
+}
+
+func TestPersistAndReadClientRegistrationRoundTrip(t *testing.T) {
+	k8s := kubetest.NewFakeK8sClient()
+
+	registration := &ClientRegistrationResponse{
+		ClientId:                "the-client-id",
+		ClientSecret:            "the-client-secret",
+		ClientSecretExpiresAt:   1234,
+		RegistrationAccessToken: "the-registration-token",
+		RegistrationClientURI:   "https://idp.example.com/register/the-client-id",
+	}
+
+	require.NoError(t, PersistClientRegistration(context.Background(), k8s, "istio-system", "kiali-openid-registration", registration))
+
+	read, err := ReadClientRegistration(context.Background(), k8s, "istio-system", "kiali-openid-registration")
+	require.NoError(t, err)
+	assert.Equal(t, registration, read)
+}
+
+func TestPersistClientRegistrationUpdatesExistingSecret(t *testing.T) {
+	k8s := kubetest.NewFakeK8sClient()
+
+	original := &ClientRegistrationResponse{ClientId: "original-id"}
+	require.NoError(t, PersistClientRegistration(context.Background(), k8s, "istio-system", "kiali-openid-registration", original))
+
+	updated := &ClientRegistrationResponse{ClientId: "updated-id"}
+	require.NoError(t, PersistClientRegistration(context.Background(), k8s, "istio-system", "kiali-openid-registration", updated))
+
+	read, err := ReadClientRegistration(context.Background(), k8s, "istio-system", "kiali-openid-registration")
+	require.NoError(t, err)
+	assert.Equal(t, "updated-id", read.ClientId)
+}
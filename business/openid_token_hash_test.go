@@ -0,0 +1,84 @@
+package business
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawTokenWithClaims builds an unsigned-looking "header.claims.signature" string; VerifyAccessToken
+// and VerifyCode only ever look at the header and claims segments, so the signature segment's
+// content is irrelevant here.
+func rawTokenWithClaims(t *testing.T, header, claims map[string]interface{}) string {
+	t.Helper()
+
+	rawHeader, err := json.Marshal(header)
+	require.NoError(t, err)
+	rawClaims, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	return base64.RawURLEncoding.EncodeToString(rawHeader) + "." + base64.RawURLEncoding.EncodeToString(rawClaims) + ".sig"
+}
+
+func atHash(value string) string {
+	digest := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(digest[:len(digest)/2])
+}
+
+func TestVerifyAccessTokenSuccess(t *testing.T) {
+	accessToken := "the-access-token"
+	token := rawTokenWithClaims(t,
+		map[string]interface{}{"alg": "RS256"},
+		map[string]interface{}{"at_hash": atHash(accessToken)},
+	)
+
+	assert.NoError(t, VerifyAccessToken(token, accessToken))
+}
+
+func TestVerifyAccessTokenMismatch(t *testing.T) {
+	token := rawTokenWithClaims(t,
+		map[string]interface{}{"alg": "RS256"},
+		map[string]interface{}{"at_hash": atHash("the-real-access-token")},
+	)
+
+	err := VerifyAccessToken(token, "a-different-access-token")
+	assert.ErrorIs(t, err, ErrInvalidAtHash)
+}
+
+func TestVerifyAccessTokenMissingClaim(t *testing.T) {
+	token := rawTokenWithClaims(t, map[string]interface{}{"alg": "RS256"}, map[string]interface{}{})
+
+	err := VerifyAccessToken(token, "the-access-token")
+	assert.ErrorIs(t, err, ErrNoAtHash)
+}
+
+func TestVerifyCodeSuccess(t *testing.T) {
+	code := "the-authorization-code"
+	token := rawTokenWithClaims(t,
+		map[string]interface{}{"alg": "ES256"},
+		map[string]interface{}{"c_hash": atHash(code)},
+	)
+
+	assert.NoError(t, VerifyCode(token, code))
+}
+
+func TestVerifyCodeMismatch(t *testing.T) {
+	token := rawTokenWithClaims(t,
+		map[string]interface{}{"alg": "ES256"},
+		map[string]interface{}{"c_hash": atHash("the-real-code")},
+	)
+
+	err := VerifyCode(token, "a-different-code")
+	assert.ErrorIs(t, err, ErrInvalidCHash)
+}
+
+func TestVerifyCodeMissingClaim(t *testing.T) {
+	token := rawTokenWithClaims(t, map[string]interface{}{"alg": "ES256"}, map[string]interface{}{})
+
+	err := VerifyCode(token, "the-authorization-code")
+	assert.ErrorIs(t, err, ErrNoCHash)
+}
@@ -0,0 +1,57 @@
+// Package config holds Kiali's runtime configuration, as loaded from its ConfigMap/CR.
+package config
+
+// OpenIdConfigIssuer describes a single OpenId Connect IdP under Auth.OpenId.Issuers, letting
+// Kiali federate with several IdPs at once instead of the single global issuer the legacy
+// IssuerUri/ClientId/ClientSecret/Scopes fields describe.
+type OpenIdConfigIssuer struct {
+	Name         string   `yaml:"name"`
+	Namespace    string   `yaml:"namespace"`
+	IssuerUri    string   `yaml:"issuer_uri"`
+	ClientId     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// OpenIdConfig holds the configuration of the "openid" authentication strategy.
+type OpenIdConfig struct {
+	ClientId              string   `yaml:"client_id"`
+	ClientSecret          string   `yaml:"client_secret"`
+	InsecureSkipVerifyTLS bool     `yaml:"insecure_skip_verify_tls"`
+	IssuerUri             string   `yaml:"issuer_uri"`
+	ResponseType          string   `yaml:"response_type"`
+	Scopes                []string `yaml:"scopes"`
+
+	// DynamicRegistration enables RFC 7591 Dynamic Client Registration against the IdP's
+	// registration_endpoint, instead of requiring ClientId/ClientSecret to be configured
+	// statically. Off by default: most IdPs require registration to be explicitly requested.
+	DynamicRegistration bool `yaml:"dynamic_registration"`
+
+	// Issuers, when set, replaces the legacy single-issuer fields above with a list of IdPs Kiali
+	// federates with simultaneously. When empty, IssuerUri/ClientId/ClientSecret/Scopes are used
+	// as a single issuer named "default", so existing single-IdP configurations keep working
+	// unchanged.
+	Issuers []OpenIdConfigIssuer `yaml:"issuers"`
+}
+
+// AuthConfig holds the configuration of every supported authentication strategy.
+type AuthConfig struct {
+	OpenId OpenIdConfig `yaml:"openid"`
+}
+
+// Config is the root of Kiali's runtime configuration.
+type Config struct {
+	Auth AuthConfig `yaml:"auth"`
+}
+
+var configuration = new(Config)
+
+// Get returns the current Config. Callers must not mutate the returned value.
+func Get() *Config {
+	return configuration
+}
+
+// Set replaces the current Config, e.g. once it has been parsed from the ConfigMap/CR.
+func Set(conf *Config) {
+	configuration = conf
+}